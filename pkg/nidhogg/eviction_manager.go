@@ -0,0 +1,145 @@
+package nidhogg
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// evictionManager evicts pods that don't tolerate a NoExecute taint once
+// their toleration window has elapsed, mirroring the behaviour of
+// Kubernetes' NoExecuteTaintManager. Pending evictions are grouped by the
+// value of the taint the gate applies (the gate key), so they can all be
+// cancelled together if the gating pod becomes Ready again before the
+// timer fires.
+type evictionManager struct {
+	client client.Client
+	queue  workqueue.RateLimitingInterface
+
+	mu     sync.Mutex
+	timers map[string]map[string]*time.Timer // gateKey -> podKey -> pending eviction timer
+}
+
+func newEvictionManager(c client.Client) *evictionManager {
+	return &evictionManager{
+		client: c,
+		queue:  workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		timers: make(map[string]map[string]*time.Timer),
+	}
+}
+
+// ScheduleEviction arranges for pod to be evicted after tolerationSeconds
+// has elapsed (immediately if nil), unless CancelGate is called for gateKey
+// first. Re-scheduling a pod that's already pending for the same gate is a
+// no-op, so repeated taint reconciles coalesce onto a single timer.
+func (m *evictionManager) ScheduleEviction(gateKey string, pod *corev1.Pod, tolerationSeconds *int64) {
+	podKey := podKeyFor(pod)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.timers[gateKey]; !ok {
+		m.timers[gateKey] = make(map[string]*time.Timer)
+	}
+	if _, pending := m.timers[gateKey][podKey]; pending {
+		return
+	}
+
+	var wait time.Duration
+	if tolerationSeconds != nil {
+		wait = time.Duration(*tolerationSeconds) * time.Second
+	}
+
+	m.timers[gateKey][podKey] = time.AfterFunc(wait, func() {
+		m.mu.Lock()
+		delete(m.timers[gateKey], podKey)
+		m.mu.Unlock()
+		m.queue.Add(podKey)
+	})
+}
+
+// CancelGate cancels every eviction pending on behalf of gateKey. Called
+// when the Daemonset pod gating the taint becomes Ready again.
+func (m *evictionManager) CancelGate(gateKey string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for podKey, timer := range m.timers[gateKey] {
+		timer.Stop()
+		delete(m.timers[gateKey], podKey)
+	}
+	delete(m.timers, gateKey)
+}
+
+// Run processes the eviction workqueue until stopCh is closed.
+func (m *evictionManager) Run(stopCh <-chan struct{}) {
+	go func() {
+		<-stopCh
+		m.queue.ShutDown()
+	}()
+	go m.worker()
+}
+
+func (m *evictionManager) worker() {
+	for m.processNextItem() {
+	}
+}
+
+func (m *evictionManager) processNextItem() bool {
+	key, shutdown := m.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer m.queue.Done(key)
+
+	if err := m.evict(key.(string)); err != nil {
+		m.queue.AddRateLimited(key)
+		return true
+	}
+	m.queue.Forget(key)
+	return true
+}
+
+func (m *evictionManager) evict(podKey string) error {
+	log := logf.Log.WithName("nidhogg").WithValues("pod", podKey)
+
+	namespace, name, ok := splitPodKey(podKey)
+	if !ok {
+		log.Info("dropping malformed eviction key")
+		return nil
+	}
+
+	pod := &corev1.Pod{}
+	err := m.client.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, pod)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Info("evicting pod for expired NoExecute toleration")
+	if err := m.client.Delete(context.TODO(), pod); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func podKeyFor(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func splitPodKey(key string) (namespace, name string, ok bool) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
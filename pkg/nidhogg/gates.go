@@ -0,0 +1,146 @@
+package nidhogg
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// PodSelector gates scheduling on any pod matching LabelSelector within
+// Namespace being Ready on the node, rather than assuming DaemonSet
+// ownership. This lets nidhogg wait on system pods managed by a
+// Deployment, a per-node StatefulSet, or anything else chosen by label.
+type PodSelector struct {
+	Name          string            `json:"name" yaml:"name"`
+	Namespace     string            `json:"namespace" yaml:"namespace"`
+	LabelSelector map[string]string `json:"labelSelector" yaml:"labelSelector"`
+	// Effect is the taint effect applied while no matching pod is ready on
+	// the node. One of NoSchedule, PreferNoSchedule or NoExecute. Defaults
+	// to NoSchedule.
+	Effect string `json:"effect" yaml:"effect"`
+}
+
+func (ps PodSelector) taintEffect() corev1.TaintEffect {
+	return parseTaintEffect(ps.Effect)
+}
+
+func parseTaintEffect(effect string) corev1.TaintEffect {
+	switch effect {
+	case string(corev1.TaintEffectPreferNoSchedule):
+		return corev1.TaintEffectPreferNoSchedule
+	case string(corev1.TaintEffectNoExecute):
+		return corev1.TaintEffectNoExecute
+	default:
+		return corev1.TaintEffectNoSchedule
+	}
+}
+
+// ReadinessGate is something nidhogg waits to become Ready on a node before
+// removing the taint it holds responsible. Daemonset and PodSelector config
+// entries are both translated into gates so calculateTaints can treat them
+// uniformly.
+type ReadinessGate interface {
+	// Taint returns the key, value and effect of the taint applied while
+	// this gate isn't satisfied.
+	Taint() (key, value string, effect corev1.TaintEffect)
+	// Pod returns the pod on nodeName that satisfies this gate, or nil if
+	// there isn't one yet.
+	Pod(h *Handler, nodeName string) (*corev1.Pod, error)
+	// Owns reports whether pod is the one this gate is waiting on, so the
+	// NoExecute eviction manager doesn't evict the pod it's gating on.
+	Owns(pod *corev1.Pod) bool
+}
+
+type daemonsetGate struct {
+	ds     Daemonset
+	key    string
+	value  string
+	effect corev1.TaintEffect
+}
+
+func (g daemonsetGate) Taint() (string, string, corev1.TaintEffect) { return g.key, g.value, g.effect }
+func (g daemonsetGate) Owns(pod *corev1.Pod) bool                   { return isOwnedBy(pod, g.ds.Name) }
+func (g daemonsetGate) Pod(h *Handler, nodeName string) (*corev1.Pod, error) {
+	return h.podGetter(nodeName, g.ds.Namespace, g.ds.Name)
+}
+
+type podSelectorGate struct {
+	ps       PodSelector
+	selector labels.Selector
+	key      string
+	value    string
+	effect   corev1.TaintEffect
+}
+
+func (g podSelectorGate) Taint() (string, string, corev1.TaintEffect) {
+	return g.key, g.value, g.effect
+}
+
+func (g podSelectorGate) Owns(pod *corev1.Pod) bool {
+	return pod.Namespace == g.ps.Namespace && g.selector.Matches(labels.Set(pod.Labels))
+}
+
+func (g podSelectorGate) Pod(h *Handler, nodeName string) (*corev1.Pod, error) {
+	pods, err := h.getPodsOnNode(nodeName)
+	if err != nil {
+		return nil, err
+	}
+	// prefer a Ready match, so any one Ready matching pod satisfies the gate
+	var match *corev1.Pod
+	for i := range pods {
+		if !g.Owns(&pods[i]) {
+			continue
+		}
+		if PodReady(&pods[i]) {
+			return &pods[i], nil
+		}
+		if match == nil {
+			match = &pods[i]
+		}
+	}
+	return match, nil
+}
+
+// buildReadinessGates translates a HandlerConfig's Daemonsets and
+// PodSelectors into a unified list of gates, preserving the existing
+// ownerRef-based behaviour for Daemonsets. defaultKey is used for every
+// gate unless a Daemonset specifies its own Taint spec. It's an error for
+// two gates to resolve to the same taint value, even under different keys:
+// calculateTaints, the eviction manager and the ready-at annotation all key
+// their bookkeeping off the value alone, so a collision would silently
+// conflate two unrelated gates' state.
+func buildReadinessGates(conf HandlerConfig, defaultKey string) ([]ReadinessGate, error) {
+	gates := make([]ReadinessGate, 0, len(conf.Daemonsets)+len(conf.PodSelectors))
+	seen := make(map[string]struct{}, len(conf.Daemonsets)+len(conf.PodSelectors))
+	for _, ds := range conf.Daemonsets {
+		key, value, effect := defaultKey, fmt.Sprintf("%s/%s", ds.Namespace, ds.Name), ds.taintEffect()
+		if ds.Taint != "" {
+			k, v, e, err := parseTaintSpec(ds.Taint)
+			if err != nil {
+				return nil, fmt.Errorf("daemonset %s/%s: %v", ds.Namespace, ds.Name, err)
+			}
+			key, value, effect = k, v, e
+		}
+		if _, ok := seen[value]; ok {
+			return nil, fmt.Errorf("daemonset %s/%s: taint value %q is already used by another gate", ds.Namespace, ds.Name, value)
+		}
+		seen[value] = struct{}{}
+		gates = append(gates, daemonsetGate{ds: ds, key: key, value: value, effect: effect})
+	}
+	for _, ps := range conf.PodSelectors {
+		key, value := defaultKey, fmt.Sprintf("%s/%s", ps.Namespace, ps.Name)
+		if _, ok := seen[value]; ok {
+			return nil, fmt.Errorf("podSelector %s/%s: taint value %q is already used by another gate", ps.Namespace, ps.Name, value)
+		}
+		seen[value] = struct{}{}
+		gates = append(gates, podSelectorGate{
+			ps:       ps,
+			selector: labels.SelectorFromSet(ps.LabelSelector),
+			key:      key,
+			value:    value,
+			effect:   ps.taintEffect(),
+		})
+	}
+	return gates, nil
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
@@ -16,7 +17,7 @@ import (
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
 
-const taintKey = "nidhogg.uswitch.com"
+const defaultTaintKey = "nidhogg.uswitch.com"
 
 // Handler performs the main business logic of the Wave controller
 type Handler struct {
@@ -26,19 +27,51 @@ type Handler struct {
 
 	nodeSelector labels.Selector
 	nodeRejecter labels.Selector
+	gates        []ReadinessGate
+
+	// taintKey is the configured HandlerConfig.TaintKey, defaulted to
+	// defaultTaintKey. taintKeys additionally includes every key a
+	// Daemonset's Taint override introduces, so the "is this our taint"
+	// checks recognise them too.
+	taintKey  string
+	taintKeys map[string]struct{}
+
+	evictionManager *evictionManager
+	podGetter       PodGetter
+	nodePodGetter   NodePodGetter
 }
 
-//HandlerConfig contains the options for Nidhogg
+// HandlerConfig contains the options for Nidhogg
 type HandlerConfig struct {
 	Daemonsets   []Daemonset       `json:"daemonsets" yaml:"daemonsets"`
+	PodSelectors []PodSelector     `json:"podSelectors" yaml:"podSelectors"`
 	NodeSelector map[string]string `json:"nodeSelector" yaml:"nodeSelector"`
 	NodeRejecter map[string]string `json:"nodeRejecter" yaml:"nodeRejecter"`
+	// TaintKey is the taint key nidhogg manages, defaulting to
+	// "nidhogg.uswitch.com". Running two nidhogg instances in one cluster
+	// requires giving each a distinct TaintKey.
+	TaintKey string `json:"taintKey" yaml:"taintKey"`
 }
 
-//Daemonset contains the name and namespace of a Daemonset
+// Daemonset contains the name and namespace of a Daemonset
 type Daemonset struct {
 	Name      string `json:"name" yaml:"name"`
 	Namespace string `json:"namespace" yaml:"namespace"`
+	// Effect is the taint effect applied while the Daemonset's pod is not
+	// ready on a node. One of NoSchedule, PreferNoSchedule or NoExecute.
+	// Defaults to NoSchedule. Ignored if Taint is set.
+	Effect string `json:"effect" yaml:"effect"`
+	// Taint overrides the taint key, value and effect applied while the
+	// pod isn't ready, in the "key=value:effect" form accepted by
+	// `kubectl taint`. Leave empty to use the handler's configured
+	// TaintKey with a "<namespace>/<name>" value and Effect above.
+	Taint string `json:"taint" yaml:"taint"`
+}
+
+// taintEffect returns the corev1.TaintEffect configured for ds, defaulting
+// to NoSchedule when Effect is unset or unrecognised.
+func (ds Daemonset) taintEffect() corev1.TaintEffect {
+	return parseTaintEffect(ds.Effect)
 }
 
 type taintChanges struct {
@@ -46,15 +79,62 @@ type taintChanges struct {
 	taintsRemoved []string
 }
 
-// NewHandler constructs a new instance of Handler
-func NewHandler(c client.Client, r record.EventRecorder, conf HandlerConfig) *Handler {
+// NewHandler constructs a new instance of Handler. podGetter is used to find
+// a Daemonset's pod on a given node, and nodePodGetter to find every pod on
+// a node for PodSelector gates and NoExecute eviction scanning; production
+// callers should build both with NewCachePodGetter/NewCacheNodePodGetter
+// against the manager's indexed cache, while tests can supply fakes. It
+// returns an error if conf contains an invalid Daemonset Taint spec, so the
+// reconciler refuses to start on bad config rather than failing taint
+// reconciliation later.
+func NewHandler(c client.Client, r record.EventRecorder, conf HandlerConfig, podGetter PodGetter, nodePodGetter NodePodGetter) (*Handler, error) {
+	taintKey := conf.TaintKey
+	if taintKey == "" {
+		taintKey = defaultTaintKey
+	}
+
+	gates, err := buildReadinessGates(conf, taintKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nidhogg config: %v", err)
+	}
+
+	taintKeys := map[string]struct{}{taintKey: {}}
+	for _, gate := range gates {
+		key, _, _ := gate.Taint()
+		taintKeys[key] = struct{}{}
+	}
+
 	return &Handler{
-		Client:       c,
-		recorder:     r,
-		config:       conf,
-		nodeSelector: labels.SelectorFromSet(conf.NodeSelector),
-		nodeRejecter: labels.SelectorFromSet(conf.NodeRejecter),
+		Client:          c,
+		recorder:        r,
+		config:          conf,
+		nodeSelector:    labels.SelectorFromSet(conf.NodeSelector),
+		nodeRejecter:    labels.SelectorFromSet(conf.NodeRejecter),
+		gates:           gates,
+		taintKey:        taintKey,
+		taintKeys:       taintKeys,
+		evictionManager: newEvictionManager(c),
+		podGetter:       podGetter,
+		nodePodGetter:   nodePodGetter,
+	}, nil
+}
+
+// isOurTaint reports whether key belongs to nidhogg: either one of the
+// exact keys gates are configured to use, or sharing the configured
+// TaintKey prefix so we can reconcile taints left by a previous config.
+func (h *Handler) isOurTaint(key string) bool {
+	if _, ok := h.taintKeys[key]; ok {
+		return true
 	}
+	return strings.HasPrefix(key, h.taintKey)
+}
+
+// Start runs the background subsystems Handler depends on, such as the
+// NoExecute eviction manager, and blocks until stopCh is closed.
+func (h *Handler) Start(stopCh <-chan struct{}) error {
+	h.evictionManager.Run(stopCh)
+	<-stopCh
+	return nil
 }
 
 // HandleNode works out what taints need to be applied to the node
@@ -62,26 +142,29 @@ func (h *Handler) HandleNode(instance *corev1.Node) (reconcile.Result, error) {
 	log := logf.Log.WithName("nidhogg")
 
 	// check whether node matches the selectors
-	var daemonsets []Daemonset
+	var gates []ReadinessGate
 	labelSet := labels.Set(instance.Labels)
 	if h.nodeSelector.Matches(labelSet) && !h.nodeRejecter.Matches(labelSet) {
-		daemonsets = h.config.Daemonsets
+		gates = h.gates
 	}
-	nodeCopy, taintChanges, err := h.calculateTaints(instance, daemonsets)
+	nodeCopy, taintChanges, err := h.calculateTaints(instance, gates)
 	if err != nil {
 		return reconcile.Result{}, fmt.Errorf("error caluclating taints for node %s: %v", instance.Name, err)
 	}
 
 	taintLess := true
 	for _, taint := range nodeCopy.Spec.Taints {
-		if strings.HasPrefix(taint.Key, taintKey) {
+		if h.isOurTaint(taint.Key) {
 			taintLess = false
 		}
 	}
-	firstTimeReady, ok := nodeCopy.Annotations[taintKey+"/first-time-ready"]
+	firstTimeReady, ok := nodeCopy.Annotations[h.taintKey+"/first-time-ready"]
 	if !ok && taintLess {
 		firstTimeReady = time.Now().Format("2006-01-02T15:04:05Z")
-		nodeCopy.Annotations[taintKey+"/first-time-ready"] = firstTimeReady
+		if nodeCopy.Annotations == nil {
+			nodeCopy.Annotations = map[string]string{}
+		}
+		nodeCopy.Annotations[h.taintKey+"/first-time-ready"] = firstTimeReady
 	}
 
 	if !reflect.DeepEqual(nodeCopy, instance) {
@@ -101,93 +184,139 @@ func (h *Handler) HandleNode(instance *corev1.Node) (reconcile.Result, error) {
 	return reconcile.Result{}, nil
 }
 
-func (h *Handler) calculateTaints(instance *corev1.Node, daemonsets []Daemonset) (*corev1.Node, taintChanges, error) {
+func (h *Handler) calculateTaints(instance *corev1.Node, gates []ReadinessGate) (*corev1.Node, taintChanges, error) {
 
 	nodeCopy := instance.DeepCopy()
 
 	var changes taintChanges
 
-	taintsToRemove := make(map[string]struct{})
+	taintsToRemove := make(map[string]corev1.Taint)
 	for _, taint := range nodeCopy.Spec.Taints {
 		// we could have some older taints from a different configuration file
 		// storing them all to reconcile from a previous state
-		if strings.HasPrefix(taint.Key, taintKey) {
-			taintsToRemove[taint.Key] = struct{}{}
+		if h.isOurTaint(taint.Key) {
+			taintsToRemove[taint.Value] = taint
 		}
 	}
-	for _, daemonset := range daemonsets {
-		taint := fmt.Sprintf("%s/%s", daemonset.Namespace, daemonset.Name)
+	for _, gate := range gates {
+		key, value, effect := gate.Taint()
 		// Get Pod for node
-		pod, err := h.getDaemonsetPod(instance.Name, daemonset)
+		pod, err := gate.Pod(h, instance.Name)
 		if err != nil {
 			return nil, taintChanges{}, fmt.Errorf("error fetching pods: %v", err)
 		}
 
-		if pod != nil && podReady(pod) {
+		if pod != nil && PodReady(pod) {
 			// if the taint is in the taintsToRemove map, it'll be removed
 			continue
 		}
 		// pod doesn't exist or is not ready
-		_, ok := taintsToRemove[taint]
+		_, ok := taintsToRemove[value]
 		if ok {
 			// we want to keep this already existing taint on it
-			delete(taintsToRemove, taint)
+			delete(taintsToRemove, value)
 			continue
 		}
 		// taint is not already present, adding it
-		changes.taintsAdded = append(changes.taintsAdded, taint)
-		nodeCopy.Spec.Taints = addTaint(nodeCopy.Spec.Taints, taint)
+		changes.taintsAdded = append(changes.taintsAdded, value)
+		nodeCopy.Spec.Taints = addTaint(nodeCopy.Spec.Taints, key, value, effect)
+		if effect == corev1.TaintEffectNoExecute {
+			h.scheduleNoExecuteEvictions(instance.Name, gate, corev1.Taint{Key: key, Value: value, Effect: effect})
+		}
 	}
-	for taint := range taintsToRemove {
-		nodeCopy.Spec.Taints = removeTaint(nodeCopy.Spec.Taints, taint)
-		changes.taintsRemoved = append(changes.taintsRemoved, taint)
+	for value, taint := range taintsToRemove {
+		nodeCopy.Spec.Taints = removeTaint(nodeCopy.Spec.Taints, taint.Key, value)
+		changes.taintsRemoved = append(changes.taintsRemoved, value)
+		h.evictionManager.CancelGate(value)
+		if nodeCopy.Annotations == nil {
+			nodeCopy.Annotations = map[string]string{}
+		}
+		nodeCopy.Annotations[readyAtAnnotation(h.taintKey, value)] = time.Now().Format(time.RFC3339)
 	}
 	return nodeCopy, changes, nil
 }
 
-func (h *Handler) getDaemonsetPod(nodeName string, ds Daemonset) (*corev1.Pod, error) {
-	opts := client.InNamespace(ds.Namespace)
-	pods := &corev1.PodList{}
-	err := h.List(context.TODO(), opts, pods)
+// readyAtAnnotation returns the per-gate annotation key recording when the
+// gate identified by taintValue ("<namespace>/<name>") last cleared, giving
+// operators per-daemonset SLO data instead of only an aggregate
+// first-time-ready annotation. Annotation keys allow at most one "/", so the
+// gate identity is folded entirely into the name segment.
+func readyAtAnnotation(baseKey, taintValue string) string {
+	return fmt.Sprintf("%s/%s.ready-at", baseKey, strings.Replace(taintValue, "/", ".", 1))
+}
+
+// scheduleNoExecuteEvictions enqueues every pod on nodeName that doesn't
+// tolerate newTaint for eviction, skipping the pod gate itself is waiting
+// on so we don't kill it before it gets a chance to become Ready.
+func (h *Handler) scheduleNoExecuteEvictions(nodeName string, gate ReadinessGate, newTaint corev1.Taint) {
+	log := logf.Log.WithName("nidhogg")
+	// Keyed by the taint's value, the same key calculateTaints cancels
+	// pending evictions under when the gate clears - not the gate's
+	// namespace/name, which can differ from the taint value once a
+	// Daemonset overrides its Taint spec.
+	gateKey := newTaint.Value
+
+	pods, err := h.getPodsOnNode(nodeName)
 	if err != nil {
-		return nil, err
+		log.Error(err, "failed listing pods for NoExecute eviction", "node", nodeName)
+		return
 	}
 
-	for _, pod := range pods.Items {
-		for _, owner := range pod.OwnerReferences {
-			if owner.Name == ds.Name {
-				if pod.Spec.NodeName == nodeName {
-					return &pod, nil
-				}
-			}
+	for i := range pods {
+		pod := &pods[i]
+		if gate.Owns(pod) {
+			continue
 		}
+		tolerates, tolerationSeconds := tolerationForTaint(pod.Spec.Tolerations, newTaint)
+		if tolerates && tolerationSeconds == nil {
+			// tolerates the taint indefinitely
+			continue
+		}
+		h.evictionManager.ScheduleEviction(gateKey, pod, tolerationSeconds)
 	}
+}
 
-	return nil, nil
+// getPodsOnNode returns every pod scheduled to nodeName, across all
+// namespaces, via the indexed nodePodGetter rather than a cluster-wide List.
+func (h *Handler) getPodsOnNode(nodeName string) ([]corev1.Pod, error) {
+	return h.nodePodGetter(nodeName)
+}
+
+func isOwnedBy(pod *corev1.Pod, name string) bool {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
-func podReady(pod *corev1.Pod) bool {
-	for _, status := range pod.Status.ContainerStatuses {
-		if status.Ready == false {
-			return false
+// tolerationForTaint reports whether any of tolerations tolerates taint,
+// and if so the TolerationSeconds it grants (nil meaning forever).
+func tolerationForTaint(tolerations []corev1.Toleration, taint corev1.Taint) (tolerates bool, tolerationSeconds *int64) {
+	for _, t := range tolerations {
+		if t.ToleratesTaint(&taint) {
+			return true, t.TolerationSeconds
 		}
 	}
-	return true
+	return false, nil
 }
 
-func addTaint(taints []corev1.Taint, taintValue string) []corev1.Taint {
+func addTaint(taints []corev1.Taint, key, taintValue string, effect corev1.TaintEffect) []corev1.Taint {
+	now := metav1.NewTime(time.Now())
 	return append(taints, corev1.Taint{
-		Key:    taintKey,
-		Value:  taintValue,
-		Effect: corev1.TaintEffectNoSchedule,
+		Key:       key,
+		Value:     taintValue,
+		Effect:    effect,
+		TimeAdded: &now,
 	})
 }
 
-func removeTaint(taints []corev1.Taint, taintValue string) []corev1.Taint {
+func removeTaint(taints []corev1.Taint, key, taintValue string) []corev1.Taint {
 	newTaints := []corev1.Taint{}
 
 	for _, taint := range taints {
-		if taint.Key == taintKey && taint.Value == taintValue {
+		if taint.Key == key && taint.Value == taintValue {
 			continue
 		}
 		newTaints = append(newTaints, taint)
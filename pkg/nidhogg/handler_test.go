@@ -0,0 +1,131 @@
+package nidhogg
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeClient is a client.Client that only supports the Update calls
+// HandleNode makes, recording the last node it was given.
+type fakeClient struct {
+	client.Client
+	updated *corev1.Node
+}
+
+func (f *fakeClient) Update(ctx context.Context, obj runtime.Object) error {
+	f.updated = obj.(*corev1.Node)
+	return nil
+}
+
+func int64ptr(v int64) *int64 { return &v }
+
+// TestCalculateTaints_NoExecuteEvictionLifecycle exercises the path the
+// three review fix commits (0bec45a, 6db94ac, 3b45e12) had to correct: a
+// NoExecute gate going not-ready schedules pending evictions for pods on
+// the node that don't tolerate the taint, and the same gate becoming ready
+// again cancels them - keyed consistently throughout by the taint's value.
+func TestCalculateTaints_NoExecuteEvictionLifecycle(t *testing.T) {
+	ready := false
+	dsPod := readyPod()
+	dsPod.Namespace = "kube-system"
+	dsPod.Name = "ds-pod"
+	dsPod.OwnerReferences = []metav1.OwnerReference{{Name: "ds"}}
+
+	podGetter := PodGetter(func(nodeName, namespace, dsName string) (*corev1.Pod, error) {
+		if !ready {
+			return nil, nil
+		}
+		return dsPod, nil
+	})
+
+	victim := readyPod()
+	victim.Namespace = "default"
+	victim.Name = "victim"
+	victim.Spec.Tolerations = []corev1.Toleration{{
+		Key:               "example.com/foo",
+		Operator:          corev1.TolerationOpEqual,
+		Value:             "bar",
+		Effect:            corev1.TaintEffectNoExecute,
+		TolerationSeconds: int64ptr(300),
+	}}
+	nodePodGetter := NodePodGetter(func(nodeName string) ([]corev1.Pod, error) {
+		return []corev1.Pod{*victim}, nil
+	})
+
+	conf := HandlerConfig{
+		Daemonsets: []Daemonset{{Name: "ds", Namespace: "kube-system", Taint: "example.com/foo=bar:NoExecute"}},
+	}
+	h, err := NewHandler(nil, record.NewFakeRecorder(10), conf, podGetter, nodePodGetter)
+	if err != nil {
+		t.Fatalf("NewHandler returned error: %v", err)
+	}
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+
+	nodeCopy, changes, err := h.calculateTaints(node, h.gates)
+	if err != nil {
+		t.Fatalf("calculateTaints returned error: %v", err)
+	}
+	if len(changes.taintsAdded) != 1 {
+		t.Fatalf("taintsAdded = %v, want one taint added", changes.taintsAdded)
+	}
+
+	h.evictionManager.mu.Lock()
+	pending := len(h.evictionManager.timers["bar"])
+	h.evictionManager.mu.Unlock()
+	if pending != 1 {
+		t.Fatalf("pending evictions for gate %q = %d, want 1", "bar", pending)
+	}
+
+	ready = true
+	nodeCopy, changes, err = h.calculateTaints(nodeCopy, h.gates)
+	if err != nil {
+		t.Fatalf("calculateTaints returned error: %v", err)
+	}
+	if len(changes.taintsRemoved) != 1 {
+		t.Fatalf("taintsRemoved = %v, want one taint removed", changes.taintsRemoved)
+	}
+
+	h.evictionManager.mu.Lock()
+	_, stillPending := h.evictionManager.timers["bar"]
+	h.evictionManager.mu.Unlock()
+	if stillPending {
+		t.Error("expected the gate becoming ready to cancel its pending evictions")
+	}
+
+	if nodeCopy.Annotations == nil {
+		t.Fatal("expected ready-at annotation to be set on a node with nil Annotations")
+	}
+	if _, ok := nodeCopy.Annotations[readyAtAnnotation(h.taintKey, "bar")]; !ok {
+		t.Error("expected ready-at annotation for the cleared gate")
+	}
+}
+
+// TestHandleNode_FirstTimeReadyAnnotationOnNilAnnotations guards against the
+// nil-map panic fixed in 6db94ac: a Node with no annotations yet must still
+// get its first-time-ready annotation written without panicking.
+func TestHandleNode_FirstTimeReadyAnnotationOnNilAnnotations(t *testing.T) {
+	fc := &fakeClient{}
+	h, err := NewHandler(fc, record.NewFakeRecorder(10), HandlerConfig{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewHandler returned error: %v", err)
+	}
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	if _, err := h.HandleNode(node); err != nil {
+		t.Fatalf("HandleNode returned error: %v", err)
+	}
+
+	if fc.updated == nil {
+		t.Fatal("expected HandleNode to update the node")
+	}
+	if _, ok := fc.updated.Annotations[h.taintKey+"/first-time-ready"]; !ok {
+		t.Error("expected first-time-ready annotation to be set")
+	}
+}
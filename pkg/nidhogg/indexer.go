@@ -0,0 +1,70 @@
+package nidhogg
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// podNodeNameIndex indexes pods by spec.nodeName, so "which pods are on
+// this node" doesn't require listing the whole cluster.
+const podNodeNameIndex = "spec.nodeName"
+
+// AddFieldIndexers registers the field indexes NewCachePodGetter and
+// NewCacheNodePodGetter rely on for O(1) pod lookups. It must be called
+// against the manager's cache before the manager is started.
+func AddFieldIndexers(c cache.Cache) error {
+	return c.IndexField(&corev1.Pod{}, podNodeNameIndex, func(obj runtime.Object) []string {
+		pod := obj.(*corev1.Pod)
+		if pod.Spec.NodeName == "" {
+			return nil
+		}
+		return []string{pod.Spec.NodeName}
+	})
+}
+
+// PodGetter finds the pod belonging to the named Daemonset that is running
+// on nodeName, returning a nil pod if there isn't one (yet).
+type PodGetter func(nodeName, namespace, dsName string) (*corev1.Pod, error)
+
+// NewCachePodGetter builds a PodGetter backed by an indexed informer cache.
+// AddFieldIndexers must have been called against c beforehand.
+func NewCachePodGetter(c cache.Cache) PodGetter {
+	return func(nodeName, namespace, dsName string) (*corev1.Pod, error) {
+		pods := &corev1.PodList{}
+		opts := client.InNamespace(namespace).MatchingField(podNodeNameIndex, nodeName)
+		if err := c.List(context.TODO(), opts, pods); err != nil {
+			return nil, err
+		}
+
+		for i := range pods.Items {
+			if isOwnedBy(&pods.Items[i], dsName) {
+				return &pods.Items[i], nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// NodePodGetter finds every pod scheduled onto nodeName, across all
+// namespaces - used by PodSelector gates and NoExecute eviction scanning,
+// neither of which can narrow down to a single namespace up front.
+type NodePodGetter func(nodeName string) ([]corev1.Pod, error)
+
+// NewCacheNodePodGetter builds a NodePodGetter backed by the same
+// podNodeNameIndex as NewCachePodGetter, so scanning all pods on a node
+// still avoids listing the whole cluster. AddFieldIndexers must have been
+// called against c beforehand.
+func NewCacheNodePodGetter(c cache.Cache) NodePodGetter {
+	return func(nodeName string) ([]corev1.Pod, error) {
+		pods := &corev1.PodList{}
+		opts := client.MatchingField(podNodeNameIndex, nodeName)
+		if err := c.List(context.TODO(), opts, pods); err != nil {
+			return nil, err
+		}
+		return pods.Items, nil
+	}
+}
@@ -0,0 +1,44 @@
+package nidhogg
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodReadyFunc reports whether pod satisfies a readiness gate.
+type PodReadyFunc func(pod *corev1.Pod) bool
+
+// PodReady decides whether a gated pod counts as Ready. It defaults to
+// defaultPodReady; replace it at init time for custom semantics (e.g.
+// "ready only after serving traffic for N seconds") without forking.
+var PodReady PodReadyFunc = defaultPodReady
+
+// defaultPodReady requires every container in spec.containers to have a
+// ready ContainerStatus, every init container to have completed
+// successfully, and the PodReady condition to be True - which naturally
+// incorporates any readiness gates set in spec.readinessGates, since that's
+// what the kubelet folds into the PodReady condition.
+func defaultPodReady(pod *corev1.Pod) bool {
+	statuses := make(map[string]corev1.ContainerStatus, len(pod.Status.ContainerStatuses))
+	for _, status := range pod.Status.ContainerStatuses {
+		statuses[status.Name] = status
+	}
+	for _, container := range pod.Spec.Containers {
+		status, ok := statuses[container.Name]
+		if !ok || !status.Ready {
+			return false
+		}
+	}
+
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.State.Terminated == nil || status.State.Terminated.ExitCode != 0 {
+			return false
+		}
+	}
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package nidhogg
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func readyPod() *corev1.Pod {
+	return &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", Ready: true},
+			},
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func TestDefaultPodReady(t *testing.T) {
+	t.Run("ready pod", func(t *testing.T) {
+		if !defaultPodReady(readyPod()) {
+			t.Error("defaultPodReady = false, want true")
+		}
+	})
+
+	t.Run("container not ready", func(t *testing.T) {
+		pod := readyPod()
+		pod.Status.ContainerStatuses[0].Ready = false
+		if defaultPodReady(pod) {
+			t.Error("defaultPodReady = true, want false")
+		}
+	})
+
+	t.Run("missing container status", func(t *testing.T) {
+		pod := readyPod()
+		pod.Status.ContainerStatuses = nil
+		if defaultPodReady(pod) {
+			t.Error("defaultPodReady = true, want false")
+		}
+	})
+
+	t.Run("init container not yet terminated", func(t *testing.T) {
+		pod := readyPod()
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{
+			{Name: "init", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}
+		if defaultPodReady(pod) {
+			t.Error("defaultPodReady = true, want false")
+		}
+	})
+
+	t.Run("init container failed", func(t *testing.T) {
+		pod := readyPod()
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{
+			{Name: "init", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}}},
+		}
+		if defaultPodReady(pod) {
+			t.Error("defaultPodReady = true, want false")
+		}
+	})
+
+	t.Run("init container succeeded", func(t *testing.T) {
+		pod := readyPod()
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{
+			{Name: "init", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}},
+		}
+		if !defaultPodReady(pod) {
+			t.Error("defaultPodReady = false, want true")
+		}
+	})
+
+	t.Run("PodReady condition false", func(t *testing.T) {
+		pod := readyPod()
+		pod.Status.Conditions[0].Status = corev1.ConditionFalse
+		if defaultPodReady(pod) {
+			t.Error("defaultPodReady = true, want false")
+		}
+	})
+
+	t.Run("no PodReady condition", func(t *testing.T) {
+		pod := readyPod()
+		pod.Status.Conditions = nil
+		if defaultPodReady(pod) {
+			t.Error("defaultPodReady = true, want false")
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package nidhogg
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// parseTaintSpec parses a taint specification in the canonical
+// "key=value:effect" form accepted by `kubectl taint` (the value may be
+// omitted, e.g. "key:effect"), validating the key, value and effect the
+// same way kubectl does.
+func parseTaintSpec(spec string) (key, value string, effect corev1.TaintEffect, err error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return "", "", "", fmt.Errorf("invalid taint %q: expected key[=value]:effect", spec)
+	}
+
+	switch corev1.TaintEffect(parts[1]) {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		effect = corev1.TaintEffect(parts[1])
+	default:
+		return "", "", "", fmt.Errorf("invalid taint %q: effect must be one of NoSchedule, PreferNoSchedule, NoExecute", spec)
+	}
+
+	kv := strings.SplitN(parts[0], "=", 2)
+	key = kv[0]
+	if len(kv) == 2 {
+		value = kv[1]
+	}
+
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return "", "", "", fmt.Errorf("invalid taint %q: invalid key %q: %s", spec, key, strings.Join(errs, "; "))
+	}
+	if value != "" {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return "", "", "", fmt.Errorf("invalid taint %q: invalid value %q: %s", spec, value, strings.Join(errs, "; "))
+		}
+	}
+
+	return key, value, effect, nil
+}
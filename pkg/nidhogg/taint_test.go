@@ -0,0 +1,84 @@
+package nidhogg
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseTaintSpec(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		wantKey    string
+		wantValue  string
+		wantEffect corev1.TaintEffect
+		wantErr    bool
+	}{
+		{
+			name:       "key value and effect",
+			spec:       "example.com/foo=bar:NoSchedule",
+			wantKey:    "example.com/foo",
+			wantValue:  "bar",
+			wantEffect: corev1.TaintEffectNoSchedule,
+		},
+		{
+			name:       "key and effect, no value",
+			spec:       "example.com/foo:NoExecute",
+			wantKey:    "example.com/foo",
+			wantValue:  "",
+			wantEffect: corev1.TaintEffectNoExecute,
+		},
+		{
+			name:       "prefer no schedule",
+			spec:       "example.com/foo=bar:PreferNoSchedule",
+			wantKey:    "example.com/foo",
+			wantValue:  "bar",
+			wantEffect: corev1.TaintEffectPreferNoSchedule,
+		},
+		{
+			name:    "missing effect",
+			spec:    "example.com/foo=bar",
+			wantErr: true,
+		},
+		{
+			name:    "unknown effect",
+			spec:    "example.com/foo=bar:Unknown",
+			wantErr: true,
+		},
+		{
+			name:    "invalid key",
+			spec:    "not a key=bar:NoSchedule",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			spec:    "example.com/foo=not a value:NoSchedule",
+			wantErr: true,
+		},
+		{
+			name:    "too many colons",
+			spec:    "example.com/foo=bar:NoSchedule:extra",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, value, effect, err := parseTaintSpec(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseTaintSpec(%q) = nil error, want error", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTaintSpec(%q) returned unexpected error: %v", tt.spec, err)
+			}
+			if key != tt.wantKey || value != tt.wantValue || effect != tt.wantEffect {
+				t.Errorf("parseTaintSpec(%q) = (%q, %q, %q), want (%q, %q, %q)",
+					tt.spec, key, value, effect, tt.wantKey, tt.wantValue, tt.wantEffect)
+			}
+		})
+	}
+}